@@ -0,0 +1,143 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// newBatchReaderCmd builds the `git cat-file --batch` command used by
+// NewBatchReader. It is a variable so tests can intercept it to count (or
+// fake) process spawns.
+var newBatchReaderCmd = func(repoPath string) *exec.Cmd {
+	// Copy GlobalCommandArgs before appending to it: it may have spare
+	// backing capacity, and appending in place would let two concurrent
+	// callers race on the same backing array.
+	args := append(append([]string{}, GlobalCommandArgs...), "cat-file", "--batch")
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	return cmd
+}
+
+// BatchReader wraps a long-lived `git cat-file --batch` process, so that
+// repeated object lookups (e.g. one per tag) reuse a single git
+// invocation instead of spawning one per object.
+type BatchReader struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// NewBatchReader starts `git cat-file --batch` in repoPath. The caller
+// must Close it when done.
+func NewBatchReader(repoPath string) (*BatchReader, error) {
+	cmd := newBatchReaderCmd(repoPath)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &BatchReader{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// Read resolves sha (an object id, or any revision `git cat-file --batch`
+// accepts) and returns its type, size, and a reader bounded to exactly
+// its contents. The returned contents reader shares the BatchReader's
+// underlying pipe and must be fully drained before the next call to
+// Read.
+func (b *BatchReader) Read(sha string) (objectType string, size int64, contents io.Reader, err error) {
+	if _, err = io.WriteString(b.stdin, sha+"\n"); err != nil {
+		return "", 0, nil, err
+	}
+
+	header, err := b.stdout.ReadString('\n')
+	if err != nil {
+		return "", 0, nil, err
+	}
+	header = strings.TrimSuffix(header, "\n")
+
+	if strings.HasSuffix(header, " missing") {
+		return "", 0, nil, fmt.Errorf("object not found: %s", sha)
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return "", 0, nil, fmt.Errorf("cat-file --batch: unexpected header %q", header)
+	}
+
+	size, err = strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("cat-file --batch: bad size in header %q: %v", header, err)
+	}
+
+	return fields[1], size, &batchObjectReader{r: b.stdout, remaining: size}, nil
+}
+
+// Close terminates the underlying `git cat-file --batch` process.
+func (b *BatchReader) Close() error {
+	b.stdin.Close()
+	return b.cmd.Wait()
+}
+
+// batchObjectReader reads exactly `remaining` bytes of object content off
+// the shared batch pipe, then discards the trailing LF git writes after
+// every object.
+type batchObjectReader struct {
+	r         *bufio.Reader
+	remaining int64
+	trimmedLF bool
+}
+
+func (o *batchObjectReader) Read(p []byte) (int, error) {
+	if o.remaining <= 0 {
+		if !o.trimmedLF {
+			o.trimmedLF = true
+			if _, err := o.r.Discard(1); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > o.remaining {
+		p = p[:o.remaining]
+	}
+
+	n, err := o.r.Read(p)
+	o.remaining -= int64(n)
+	return n, err
+}
+
+// Batch returns the repository's long-lived `git cat-file --batch`
+// reader, lazily starting it on first use. Repository.Close is
+// responsible for closing it.
+func (repo *Repository) Batch() (*BatchReader, error) {
+	if repo.batchReader == nil {
+		b, err := NewBatchReader(repo.Path)
+		if err != nil {
+			return nil, err
+		}
+		repo.batchReader = b
+	}
+	return repo.batchReader, nil
+}
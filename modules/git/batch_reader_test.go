@@ -0,0 +1,80 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func runGitForTest(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// TestGetTagSharesOneBatchProcess asserts that repeated getTag lookups
+// share a single `git cat-file --batch` process, instead of spawning a
+// new `git cat-file` process per lookup as before. This only counts
+// cat-file --batch spawns, not every git child process the test setup
+// itself makes.
+func TestGetTagSharesOneBatchProcess(t *testing.T) {
+	repoPath := t.TempDir()
+	runGitForTest(t, repoPath, "init", "-q", repoPath)
+	runGitForTest(t, repoPath, "-C", repoPath, "commit", "--allow-empty", "-q", "-m", "initial")
+	commitID := runGitForTest(t, repoPath, "-C", repoPath, "rev-parse", "HEAD")
+
+	// Create the tags in one process instead of one `git tag` spawn each.
+	const tagCount = 25
+	var refUpdates strings.Builder
+	for i := 0; i < tagCount; i++ {
+		fmt.Fprintf(&refUpdates, "create refs/tags/v%d %s\n", i, commitID)
+	}
+	cmd := exec.Command("git", "-C", repoPath, "update-ref", "--stdin")
+	cmd.Stdin = strings.NewReader(refUpdates.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git update-ref --stdin: %v\n%s", err, out)
+	}
+
+	spawned := 0
+	old := newBatchReaderCmd
+	newBatchReaderCmd = func(dir string) *exec.Cmd {
+		spawned++
+		return old(dir)
+	}
+	defer func() { newBatchReaderCmd = old }()
+
+	id, err := NewIDFromString(commitID)
+	if err != nil {
+		t.Fatalf("NewIDFromString: %v", err)
+	}
+
+	repo := &Repository{Path: repoPath}
+	for i := 0; i < tagCount; i++ {
+		// Reset the cache each time so getTag actually calls through to
+		// the batch reader instead of short-circuiting on a cache hit --
+		// what we want to exercise here is many Read calls sharing one
+		// process, as getTag would do for tagCount distinct tags.
+		repo.tagCache = newObjectCache()
+		if _, err := repo.getTag(id); err != nil {
+			t.Fatalf("getTag: %v", err)
+		}
+	}
+
+	if repo.batchReader != nil {
+		repo.batchReader.Close()
+	}
+
+	if spawned != 1 {
+		t.Fatalf("expected exactly one cat-file --batch process, got %d", spawned)
+	}
+}
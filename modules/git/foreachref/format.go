@@ -0,0 +1,128 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package foreachref parses the output of `git for-each-ref`, so that
+// callers can read ref metadata (e.g. tags) in a single `git` invocation
+// instead of shelling out once per ref.
+package foreachref
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Field is the name of a field understood by `git for-each-ref --format`,
+// e.g. "refname" or "objectname". A field may carry a ":"-suffixed
+// modifier, such as "taggerdate:iso8601-strict".
+type Field string
+
+// Field names used when reading tags. This is not an exhaustive list of
+// everything `git for-each-ref` supports, only what this package's
+// callers currently need.
+const (
+	RefName    Field = "refname"
+	ObjectType Field = "objecttype"
+	ObjectName Field = "objectname"
+	// DerefObjectName is the id of the object a tag ultimately points to,
+	// i.e. the commit an annotated tag was made against.
+	DerefObjectName Field = "*objectname"
+	TaggerName      Field = "taggername"
+	// TaggerEmail uses git's ":trim" modifier so the value is the bare
+	// address (e.g. "a@b.c"), not "<a@b.c>" as %(taggeremail) alone
+	// would yield -- matching what parseTagData stores for the
+	// single-tag lookup path.
+	TaggerEmail Field = "taggeremail:trim"
+	TaggerDate  Field = "taggerdate:iso8601-strict"
+	Contents    Field = "contents"
+)
+
+// formatFieldSep is the `--format` escape sequence git expands to a
+// literal NUL byte between fields. It is also appended after the final
+// field (see Flag), so every record ends in a NUL too.
+//
+// fieldSep/recordSep below are the literal bytes git actually emits --
+// NOT the "%00" escape sequence, which only has meaning inside the
+// --format string itself and never appears in the output. Parsing must
+// split on the real byte, not the escape.
+const formatFieldSep = "%00"
+
+// fieldSep is the literal byte git writes for each formatFieldSep.
+// recordSep is the trailing NUL from Flag()'s final formatFieldSep
+// followed by the newline `git for-each-ref` itself appends after every
+// record. Both are NUL-based, which cannot appear in a git ref, object
+// id or commit message, so they survive arbitrary tag message content
+// (including embedded newlines) without ambiguity.
+const (
+	fieldSep  = "\x00"
+	recordSep = fieldSep + "\n"
+)
+
+// Format builds the `--format` argument for `git for-each-ref` and parses
+// the output it produces back into records. Callers request only the
+// fields they need, in the order they want them.
+type Format struct {
+	fields []Field
+}
+
+// NewFormat returns a Format requesting exactly the given fields, in order.
+func NewFormat(fields ...Field) *Format {
+	return &Format{fields: fields}
+}
+
+// Flag returns the literal string to pass as `--format=<...>` to
+// `git for-each-ref`. A trailing formatFieldSep is appended after the
+// last field so every record (the last one included) ends with a NUL
+// before git's own newline, giving Parse an unambiguous record
+// terminator even when a field's content has embedded newlines.
+func (f *Format) Flag() string {
+	parts := make([]string, len(f.fields))
+	for i, field := range f.fields {
+		parts[i] = fmt.Sprintf("%%(%s)", field)
+	}
+	return strings.Join(parts, formatFieldSep) + formatFieldSep
+}
+
+// Record is one parsed for-each-ref entry, keyed by the fields the Format
+// was created with.
+type Record map[Field]string
+
+// Parse reads raw `git for-each-ref` output produced using Flag() from r
+// and invokes fn with each decoded record, in order. It stops at the
+// first error returned by fn, or if reading fails.
+func (f *Format) Parse(r *bufio.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	scanner.Split(f.splitRecords)
+
+	for scanner.Scan() {
+		values := bytes.Split(scanner.Bytes(), []byte(fieldSep))
+		record := make(Record, len(f.fields))
+		for i, field := range f.fields {
+			if i < len(values) {
+				record[field] = string(values[i])
+			}
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (f *Format) splitRecords(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.Index(data, []byte(recordSep)); i >= 0 {
+		return i + len(recordSep), data[:i], nil
+	}
+	if atEOF {
+		// No trailing recordSep found; return what's left, stripped of
+		// any partial terminator, rather than dropping it.
+		return len(data), bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte(fieldSep)), nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+// Repository represents a Git repository on disk.
+type Repository struct {
+	Path string
+
+	tagCache *ObjectCache
+
+	// batchReader is the lazily-started `git cat-file --batch` process
+	// backing getTag; see Batch in batch_reader.go. It is nil until the
+	// first call to Batch.
+	batchReader *BatchReader
+}
+
+// Close releases resources held open for this repository, in particular
+// the long-lived `git cat-file --batch` process started by Batch, if one
+// was ever started. It is safe to call Close more than once.
+func (repo *Repository) Close() error {
+	if repo.batchReader == nil {
+		return nil
+	}
+	err := repo.batchReader.Close()
+	repo.batchReader = nil
+	return err
+}
@@ -6,12 +6,40 @@
 package git
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
+	"time"
 
-	"github.com/mcuadros/go-version"
-	"gopkg.in/src-d/go-git.v4/plumbing"
+	"code.gitea.io/gitea/modules/git/foreachref"
 )
 
+// CreateTagOptions contains optional arguments for creating an annotated
+// tag. All fields are optional.
+type CreateTagOptions struct {
+	// Tagger is the identity recorded as the tag's author. When nil, git
+	// records its own configured committer identity instead.
+	Tagger *Signature
+	// Sign asks git to GPG/SSH-sign the tag.
+	Sign bool
+	// SigningKey selects which key to sign with: a GPG fingerprint, or
+	// (for SSH signing) the path to a private key or its fingerprint.
+	// Leave empty to use the configured default signing key.
+	SigningKey string
+	// Format is the gpg.format to sign with when Sign is set, e.g.
+	// "openpgp" or "ssh". There is no reliable way to infer this from
+	// SigningKey alone (an SSH key may be referenced by a fingerprint
+	// such as "SHA256:..." with no path or "ssh-" prefix), so callers
+	// must say which it is. Leave empty to use the repository/global
+	// git config's gpg.format default.
+	Format string
+	// Force overwrites an existing tag with the same name.
+	Force bool
+}
+
 // TagPrefix tags prefix path on the repository
 const TagPrefix = "refs/tags/"
 
@@ -22,11 +50,7 @@ func IsTagExist(repoPath, name string) bool {
 
 // IsTagExist returns true if given tag exists in the repository.
 func (repo *Repository) IsTagExist(name string) bool {
-	_, err := repo.gogitRepo.Reference(plumbing.ReferenceName(TagPrefix+name), true)
-	if err != nil {
-		return false
-	}
-	return true
+	return IsTagExist(repo.Path, name)
 }
 
 // CreateTag create one tag in the repository
@@ -35,6 +59,52 @@ func (repo *Repository) CreateTag(name, revision string) error {
 	return err
 }
 
+// CreateAnnotatedTag creates an annotated, and optionally GPG- or
+// SSH-signed, tag in the repository.
+func (repo *Repository) CreateAnnotatedTag(name, message, revision string, opts CreateTagOptions) error {
+	var args []string
+	if opts.Sign && len(opts.Format) > 0 {
+		args = append(args, "-c", "gpg.format="+opts.Format)
+	}
+
+	args = append(args, "tag", "-a", "-m", message)
+	if opts.Sign {
+		if len(opts.SigningKey) > 0 {
+			args = append(args, "-u", opts.SigningKey)
+		} else {
+			args = append(args, "-s")
+		}
+	}
+	if opts.Force {
+		args = append(args, "-f")
+	}
+	args = append(args, name, revision)
+
+	cmd := NewCommand(args...)
+	if opts.Tagger != nil {
+		cmd.AddEnvs(
+			"GIT_COMMITTER_NAME="+opts.Tagger.Name,
+			"GIT_COMMITTER_EMAIL="+opts.Tagger.Email,
+			"GIT_COMMITTER_DATE="+opts.Tagger.When.Format(time.RFC3339),
+		)
+	}
+
+	_, err := cmd.RunInDir(repo.Path)
+	return err
+}
+
+// DeleteTag deletes a tag from the repository.
+func (repo *Repository) DeleteTag(name string) error {
+	_, err := NewCommand("tag", "-d", name).RunInDir(repo.Path)
+	return err
+}
+
+// PushTag pushes a single tag to the given remote.
+func (repo *Repository) PushTag(remote, name string) error {
+	_, err := NewCommand("push", remote, TagPrefix+name).RunInDir(repo.Path)
+	return err
+}
+
 func (repo *Repository) getTag(id SHA1) (*Tag, error) {
 	t, ok := repo.tagCache.Get(id.String())
 	if ok {
@@ -42,15 +112,22 @@ func (repo *Repository) getTag(id SHA1) (*Tag, error) {
 		return t.(*Tag), nil
 	}
 
-	// Get tag type
-	tp, err := NewCommand("cat-file", "-t", id.String()).RunInDir(repo.Path)
+	batch, err := repo.Batch()
+	if err != nil {
+		return nil, err
+	}
+
+	objectType, _, contents, err := batch.Read(id.String())
 	if err != nil {
 		return nil, err
 	}
-	tp = strings.TrimSpace(tp)
 
 	// Tag is a commit.
-	if ObjectType(tp) == ObjectCommit {
+	if ObjectType(objectType) == ObjectCommit {
+		if _, err := io.Copy(ioutil.Discard, contents); err != nil {
+			return nil, err
+		}
+
 		tag := &Tag{
 			ID:     id,
 			Object: id,
@@ -63,7 +140,7 @@ func (repo *Repository) getTag(id SHA1) (*Tag, error) {
 	}
 
 	// Tag with message.
-	data, err := NewCommand("cat-file", "-p", id.String()).RunInDirBytes(repo.Path)
+	data, err := ioutil.ReadAll(contents)
 	if err != nil {
 		return nil, err
 	}
@@ -100,52 +177,132 @@ func (repo *Repository) GetTag(name string) (*Tag, error) {
 	return tag, nil
 }
 
+// tagForEachRefFormat is the set of fields read from `git for-each-ref` to
+// build a *Tag without shelling out again per tag.
+var tagForEachRefFormat = foreachref.NewFormat(
+	foreachref.RefName,
+	foreachref.ObjectType,
+	foreachref.ObjectName,
+	foreachref.DerefObjectName,
+	foreachref.TaggerName,
+	foreachref.TaggerEmail,
+	foreachref.TaggerDate,
+	foreachref.Contents,
+)
+
 // GetTagInfos returns all tag infos of the repository.
 func (repo *Repository) GetTagInfos() ([]*Tag, error) {
-	// TODO this a slow implementation, makes one git command per tag
-	stdout, err := NewCommand("tag").RunInDir(repo.Path)
+	return repo.GetTagInfosBetween(0, 0)
+}
+
+// GetTagInfosBetween returns tag infos of the repository in time-descending
+// order, skipping the first skip tags and returning at most limit of them.
+// A limit of 0 means no limit.
+func (repo *Repository) GetTagInfosBetween(skip, limit int) ([]*Tag, error) {
+	stdout, err := NewCommand("for-each-ref", "--format="+tagForEachRefFormat.Flag(), "--sort=-taggerdate", TagPrefix).RunInDirBytes(repo.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	tagNames := strings.Split(stdout, "\n")
-	var tags = make([]*Tag, 0, len(tagNames))
-	for _, tagName := range tagNames {
-		tagName = strings.TrimSpace(tagName)
-		if len(tagName) == 0 {
-			continue
+	tags := make([]*Tag, 0, 10)
+	i := 0
+	parseErr := tagForEachRefFormat.Parse(bufio.NewReader(bytes.NewReader(stdout)), func(record foreachref.Record) error {
+		defer func() { i++ }()
+		if i < skip {
+			return nil
+		}
+		if limit > 0 && i >= skip+limit {
+			return nil
 		}
 
-		tag, err := repo.GetTag(tagName)
+		tag, err := parseTagRecord(record)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("parse tag: %v", err)
 		}
+		tag.repo = repo
+		repo.tagCache.Set(tag.ID.String(), tag)
 		tags = append(tags, tag)
+		return nil
+	})
+	if parseErr != nil {
+		return nil, parseErr
 	}
+
 	sortTagsByTime(tags)
 	return tags, nil
 }
 
-// GetTags returns all tags of the repository.
-func (repo *Repository) GetTags() ([]string, error) {
-	var tagNames []string
+// parseTagRecord builds a *Tag from a for-each-ref record produced with
+// tagForEachRefFormat.
+func parseTagRecord(record foreachref.Record) (*Tag, error) {
+	id, err := NewIDFromString(record[foreachref.ObjectName])
+	if err != nil {
+		return nil, err
+	}
+
+	tag := &Tag{
+		Name: strings.TrimPrefix(record[foreachref.RefName], TagPrefix),
+		ID:   id,
+	}
+
+	if ObjectType(record[foreachref.ObjectType]) == ObjectCommit {
+		// Lightweight tag: points directly at the commit, no tagger or message.
+		tag.Object = id
+		tag.Type = string(ObjectCommit)
+		return tag, nil
+	}
+
+	tag.Type = string(ObjectTag)
+	tag.Message = record[foreachref.Contents]
+
+	if derefID := record[foreachref.DerefObjectName]; len(derefID) > 0 {
+		object, err := NewIDFromString(derefID)
+		if err != nil {
+			return nil, err
+		}
+		tag.Object = object
+	}
 
-	tags, err := repo.gogitRepo.Tags()
+	if taggerDate := record[foreachref.TaggerDate]; len(taggerDate) > 0 {
+		when, err := time.Parse(time.RFC3339, taggerDate)
+		if err != nil {
+			return nil, fmt.Errorf("parse taggerdate %q: %v", taggerDate, err)
+		}
+		tag.Tagger = &Signature{
+			Name:  record[foreachref.TaggerName],
+			Email: record[foreachref.TaggerEmail],
+			When:  when,
+		}
+	}
+
+	return tag, nil
+}
+
+// tagNameForEachRefFormat reads just the ref name, for callers that only
+// need tag names rather than full tag metadata.
+var tagNameForEachRefFormat = foreachref.NewFormat(foreachref.RefName)
+
+// GetTags returns all tags of the repository in time-descending order
+// (most recently tagged first), matching GetTagInfos/GetTagInfosBetween.
+//
+// This replaces the previous semantic-version ordering (via
+// github.com/mcuadros/go-version), which went with go-git's Tags()
+// iteration that this function no longer uses. There are no other
+// callers of GetTags in this tree; any caller that relies on version
+// ordering rather than recency should sort the returned names itself.
+func (repo *Repository) GetTags() ([]string, error) {
+	stdout, err := NewCommand("for-each-ref", "--format="+tagNameForEachRefFormat.Flag(), "--sort=-taggerdate", TagPrefix).RunInDirBytes(repo.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	tags.ForEach(func(tag *plumbing.Reference) error {
-		tagNames = append(tagNames, strings.TrimPrefix(tag.Name().String(), TagPrefix))
+	var tagNames []string
+	err = tagNameForEachRefFormat.Parse(bufio.NewReader(bytes.NewReader(stdout)), func(record foreachref.Record) error {
+		tagNames = append(tagNames, strings.TrimPrefix(record[foreachref.RefName], TagPrefix))
 		return nil
 	})
-
-	version.Sort(tagNames)
-
-	// Reverse order
-	for i := 0; i < len(tagNames)/2; i++ {
-		j := len(tagNames) - i - 1
-		tagNames[i], tagNames[j] = tagNames[j], tagNames[i]
+	if err != nil {
+		return nil, err
 	}
 
 	return tagNames, nil